@@ -0,0 +1,361 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package broker
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/jeffail/benthos/lib/types"
+	"github.com/jeffail/util/log"
+	"github.com/jeffail/util/metrics"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+// FanOutRetryConfig - Configuration for the FanOutRetry broker type.
+type FanOutRetryConfig struct {
+	// Guarantee - "at_least_once" retries failing outputs until they succeed or the retry budget
+	// is exhausted, only then acking upstream. "best_effort" preserves the original FanOut
+	// behaviour of acking upstream as long as at least one output succeeded, without retries.
+	Guarantee string `json:"guarantee" yaml:"guarantee"`
+
+	InitialInterval time.Duration `json:"initial_interval" yaml:"initial_interval"`
+	MaxInterval     time.Duration `json:"max_interval" yaml:"max_interval"`
+	MaxElapsedTime  time.Duration `json:"max_elapsed_time" yaml:"max_elapsed_time"`
+	Multiplier      float64       `json:"multiplier" yaml:"multiplier"`
+}
+
+// NewFanOutRetryConfig - Creates a new FanOutRetryConfig with default values.
+func NewFanOutRetryConfig() FanOutRetryConfig {
+	return FanOutRetryConfig{
+		Guarantee:       "at_least_once",
+		InitialInterval: time.Millisecond * 500,
+		MaxInterval:     time.Second * 10,
+		MaxElapsedTime:  time.Minute,
+		Multiplier:      2,
+	}
+}
+
+// backOff - Creates a fresh backoff.BackOff from the config, used once per failing output per
+// message so that elapsed-time budgets don't leak across messages.
+func (c FanOutRetryConfig) backOff() backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = c.InitialInterval
+	b.MaxInterval = c.MaxInterval
+	b.MaxElapsedTime = c.MaxElapsedTime
+	b.Multiplier = c.Multiplier
+	b.Reset()
+	return b
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// FanOutRetry - A broker that implements types.Consumer and broadcasts each message out to an
+// array of outputs, retrying individual failing outputs with a backoff before giving up on them
+// and forwarding the message to a dead-letter output.
+type FanOutRetry struct {
+	running int32
+
+	logger log.Modular
+	stats  metrics.Type
+
+	conf FanOutRetryConfig
+
+	messages     <-chan types.Message
+	responseChan chan types.Response
+
+	outputMsgChans []chan types.Message
+	outputs        []types.Consumer
+
+	deadLetterMsgChan chan types.Message
+	deadLetter        types.Consumer
+
+	closedChan chan struct{}
+	closeChan  chan struct{}
+}
+
+// NewFanOutRetry - Create a new FanOutRetry type by providing outputs and a dead-letter output.
+func NewFanOutRetry(
+	outputs []types.Consumer, deadLetter types.Consumer, conf FanOutRetryConfig,
+	logger log.Modular, stats metrics.Type,
+) (*FanOutRetry, error) {
+	o := &FanOutRetry{
+		running:      1,
+		stats:        stats,
+		logger:       logger.NewModule(".broker.fan_out_retry"),
+		conf:         conf,
+		messages:     nil,
+		responseChan: make(chan types.Response),
+		outputs:      outputs,
+		deadLetter:   deadLetter,
+		closedChan:   make(chan struct{}),
+		closeChan:    make(chan struct{}),
+	}
+	o.outputMsgChans = make([]chan types.Message, len(o.outputs))
+	for i := range o.outputMsgChans {
+		o.outputMsgChans[i] = make(chan types.Message)
+		if err := o.outputs[i].StartReceiving(o.outputMsgChans[i]); err != nil {
+			return nil, err
+		}
+	}
+	if o.deadLetter != nil {
+		o.deadLetterMsgChan = make(chan types.Message)
+		if err := o.deadLetter.StartReceiving(o.deadLetterMsgChan); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// StartReceiving - Assigns a new messages channel for the broker to read.
+func (o *FanOutRetry) StartReceiving(msgs <-chan types.Message) error {
+	if o.messages != nil {
+		return types.ErrAlreadyStarted
+	}
+	o.messages = msgs
+
+	go o.loop()
+	return nil
+}
+
+// sendToAll - Sends a message to the channel of every output listed in indexes without waiting for
+// any response, so that those outputs start processing concurrently rather than one at a time.
+func (o *FanOutRetry) sendToAll(indexes []int, msg types.Message) error {
+	for _, i := range indexes {
+		select {
+		case o.outputMsgChans[i] <- msg:
+		case <-o.closeChan:
+			return types.ErrTypeClosed
+		}
+	}
+	return nil
+}
+
+// awaitResponse - Blocks for a single output's response, assuming a message has already been sent
+// to it via sendToAll.
+func (o *FanOutRetry) awaitResponse(i int) error {
+	select {
+	case res, open := <-o.outputs[i].ResponseChan():
+		if !open {
+			o.logger.Warnln("Closing fan_out_retry broker due to closed output")
+			return types.ErrTypeClosed
+		}
+		return res.Error()
+	case <-o.closeChan:
+		return types.ErrTypeClosed
+	}
+}
+
+// dispatch - Sends a message to a single output and blocks for its response. Used for the
+// per-output retry pass, where outputs are no longer in lock-step with one another.
+func (o *FanOutRetry) dispatch(i int, msg types.Message) error {
+	if err := o.sendToAll([]int{i}, msg); err != nil {
+		return err
+	}
+	return o.awaitResponse(i)
+}
+
+// dispatchWithRetry - Sends a message to a single output, retrying with backoff against that
+// output alone until it succeeds or the retry budget is exhausted.
+func (o *FanOutRetry) dispatchWithRetry(i int, msg types.Message) error {
+	b := o.conf.backOff()
+	for {
+		err := o.dispatch(i, msg)
+		if err == nil {
+			return nil
+		}
+		if err == types.ErrTypeClosed {
+			return err
+		}
+		wait := b.NextBackOff()
+		if wait == backoff.Stop {
+			return fmt.Errorf("retry budget exhausted for output %v: %v", i, err)
+		}
+		o.stats.Incr(fmt.Sprintf("broker.fan_out.output.%v.retries", i), 1)
+		select {
+		case <-time.After(wait):
+		case <-o.closeChan:
+			return types.ErrTypeClosed
+		}
+	}
+}
+
+// loop - Internal loop brokers incoming messages to many outputs, retrying and dead-lettering as
+// required by the configured guarantee.
+func (o *FanOutRetry) loop() {
+	defer func() {
+		for _, c := range o.outputMsgChans {
+			close(c)
+		}
+		if o.deadLetterMsgChan != nil {
+			close(o.deadLetterMsgChan)
+		}
+		close(o.responseChan)
+		close(o.closedChan)
+	}()
+
+	var open bool
+	for atomic.LoadInt32(&o.running) == 1 {
+		var msg types.Message
+		if msg, open = <-o.messages; !open {
+			return
+		}
+		o.stats.Incr("broker.fan_out.messages.received", 1)
+
+		allIndexes := make([]int, len(o.outputs))
+		for i := range o.outputs {
+			allIndexes[i] = i
+		}
+
+		// Broadcast to every output first, then collect responses, so a slow output doesn't stall
+		// delivery to the others.
+		if err := o.sendToAll(allIndexes, msg); err != nil {
+			return
+		}
+
+		responses := types.NewMappedResponse()
+		var failed []int
+		for i := range o.outputs {
+			if err := o.awaitResponse(i); err != nil {
+				if err == types.ErrTypeClosed {
+					return
+				}
+				responses.Errors[i] = err
+				failed = append(failed, i)
+				continue
+			}
+			o.stats.Incr("broker.fan_out.messages.sent", 1)
+		}
+
+		if len(failed) > 0 && o.conf.Guarantee == "at_least_once" {
+			// Retry each failing output concurrently and independently of the others.
+			var wg sync.WaitGroup
+			var mut sync.Mutex
+			var closed int32
+			for _, i := range failed {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					err := o.dispatchWithRetry(i, msg)
+					mut.Lock()
+					defer mut.Unlock()
+					if err == nil {
+						delete(responses.Errors, i)
+					} else if err == types.ErrTypeClosed {
+						atomic.StoreInt32(&closed, 1)
+					} else {
+						responses.Errors[i] = err
+					}
+				}(i)
+			}
+			wg.Wait()
+			if atomic.LoadInt32(&closed) == 1 {
+				return
+			}
+
+			if len(responses.Errors) > 0 && o.deadLetter != nil {
+				for i := range responses.Errors {
+					o.logger.Errorf("Output %v exhausted retries, dead-lettering: %v\n", i, responses.Errors[i])
+				}
+				o.stats.Incr("broker.fan_out.output.dead_lettered", 1)
+				select {
+				case o.deadLetterMsgChan <- msg:
+				case <-o.closeChan:
+					return
+				}
+				var dlRes types.Response
+				select {
+				case res, open := <-o.deadLetter.ResponseChan():
+					if !open {
+						return
+					}
+					dlRes = res
+				case <-o.closeChan:
+					return
+				}
+				if dlRes.Error() == nil {
+					// The message has been handed off to the dead-letter output successfully, so
+					// it's fully accounted for and the upstream source should not redeliver it.
+					responses.Errors = map[int]error{}
+				} else {
+					// The dead-letter write itself failed: leave the original per-output errors in
+					// place so the MappedResponse is still returned and the upstream source
+					// redelivers, rather than silently losing the message.
+					o.logger.Errorf("Dead-letter output failed: %v\n", dlRes.Error())
+				}
+			}
+		}
+
+		var res types.Response
+		if o.conf.Guarantee == "at_least_once" {
+			if len(responses.Errors) == 0 {
+				res = types.NewSimpleResponse(nil)
+			} else {
+				res = responses
+			}
+		} else {
+			// best_effort mirrors the original FanOut semantics: ack success as long as at least
+			// one output received the message, only reporting an error when every output failed.
+			if len(responses.Errors) < len(o.outputs) {
+				res = types.NewSimpleResponse(nil)
+			} else {
+				res = responses
+			}
+		}
+		select {
+		case o.responseChan <- res:
+		case <-o.closeChan:
+			return
+		}
+	}
+}
+
+// ResponseChan - Returns the response channel.
+func (o *FanOutRetry) ResponseChan() <-chan types.Response {
+	return o.responseChan
+}
+
+// CloseAsync - Shuts down the FanOutRetry broker and stops processing requests.
+func (o *FanOutRetry) CloseAsync() {
+	if atomic.CompareAndSwapInt32(&o.running, 1, 0) {
+		close(o.closeChan)
+	}
+}
+
+// WaitForClose - Blocks until the FanOutRetry broker has closed down.
+func (o *FanOutRetry) WaitForClose(timeout time.Duration) error {
+	select {
+	case <-o.closedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+//--------------------------------------------------------------------------------------------------
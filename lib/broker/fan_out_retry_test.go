@@ -0,0 +1,300 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package broker
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jeffail/benthos/lib/types"
+	"github.com/jeffail/util/log"
+	"github.com/jeffail/util/metrics"
+)
+
+var fanOutRetryLogConfig = log.LoggerConfig{LogLevel: "NONE"}
+
+// mockConsumer - A types.Consumer whose response to each message is driven by a respond callback,
+// for exercising FanOutRetry's retry and dead-letter paths without a real output.
+type mockConsumer struct {
+	respond func(types.Message) error
+
+	messages     <-chan types.Message
+	responseChan chan types.Response
+
+	closeOnce  sync.Once
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+func newMockConsumer(respond func(types.Message) error) *mockConsumer {
+	return &mockConsumer{
+		respond:      respond,
+		responseChan: make(chan types.Response),
+		closeChan:    make(chan struct{}),
+		closedChan:   make(chan struct{}),
+	}
+}
+
+func (m *mockConsumer) StartReceiving(msgs <-chan types.Message) error {
+	if m.messages != nil {
+		return types.ErrAlreadyStarted
+	}
+	m.messages = msgs
+	go m.loop()
+	return nil
+}
+
+func (m *mockConsumer) loop() {
+	defer func() {
+		close(m.responseChan)
+		close(m.closedChan)
+	}()
+	for {
+		msg, open := <-m.messages
+		if !open {
+			return
+		}
+		res := types.NewSimpleResponse(m.respond(msg))
+		select {
+		case m.responseChan <- res:
+		case <-m.closeChan:
+			return
+		}
+	}
+}
+
+func (m *mockConsumer) ResponseChan() <-chan types.Response {
+	return m.responseChan
+}
+
+func (m *mockConsumer) CloseAsync() {
+	m.closeOnce.Do(func() { close(m.closeChan) })
+}
+
+func (m *mockConsumer) WaitForClose(timeout time.Duration) error {
+	select {
+	case <-m.closedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+// fastRetryConfig - A FanOutRetryConfig tuned for quick retries so tests don't block on the real
+// default backoff intervals.
+func fastRetryConfig(guarantee string) FanOutRetryConfig {
+	conf := NewFanOutRetryConfig()
+	conf.Guarantee = guarantee
+	conf.InitialInterval = time.Millisecond
+	conf.MaxInterval = time.Millisecond * 5
+	conf.MaxElapsedTime = time.Millisecond * 50
+	return conf
+}
+
+func roundTrip(t *testing.T, o *FanOutRetry, msgChan chan types.Message, msg types.Message) types.Response {
+	t.Helper()
+	select {
+	case msgChan <- msg:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out sending message")
+	}
+	select {
+	case res := <-o.ResponseChan():
+		return res
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for response")
+	}
+	return nil
+}
+
+func TestFanOutRetryAllSucceed(t *testing.T) {
+	outputs := []types.Consumer{
+		newMockConsumer(func(types.Message) error { return nil }),
+		newMockConsumer(func(types.Message) error { return nil }),
+	}
+
+	o, err := NewFanOutRetry(
+		outputs, nil, fastRetryConfig("at_least_once"),
+		log.NewLogger(os.Stdout, fanOutRetryLogConfig), metrics.DudType{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgChan := make(chan types.Message)
+	if err = o.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+
+	res := roundTrip(t, o, msgChan, types.Message{Parts: [][]byte{[]byte("foo")}})
+	if res.Error() != nil {
+		t.Errorf("Unexpected error: %v", res.Error())
+	}
+
+	o.CloseAsync()
+	if err = o.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFanOutRetrySucceedsAfterRetry(t *testing.T) {
+	var attempts int32
+	outputs := []types.Consumer{
+		newMockConsumer(func(types.Message) error {
+			if atomic.AddInt32(&attempts, 1) < 3 {
+				return fmt.Errorf("not yet")
+			}
+			return nil
+		}),
+	}
+
+	o, err := NewFanOutRetry(
+		outputs, nil, fastRetryConfig("at_least_once"),
+		log.NewLogger(os.Stdout, fanOutRetryLogConfig), metrics.DudType{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgChan := make(chan types.Message)
+	if err = o.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+
+	res := roundTrip(t, o, msgChan, types.Message{Parts: [][]byte{[]byte("foo")}})
+	if res.Error() != nil {
+		t.Errorf("Unexpected error: %v", res.Error())
+	}
+	if exp, actual := int32(3), atomic.LoadInt32(&attempts); exp != actual {
+		t.Errorf("Wrong attempt count: %v != %v", actual, exp)
+	}
+
+	o.CloseAsync()
+	if err = o.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFanOutRetryDeadLettersExhaustedOutput(t *testing.T) {
+	failErr := fmt.Errorf("always fails")
+	outputs := []types.Consumer{
+		newMockConsumer(func(types.Message) error { return failErr }),
+	}
+	deadLetter := newMockConsumer(func(types.Message) error { return nil })
+
+	o, err := NewFanOutRetry(
+		outputs, deadLetter, fastRetryConfig("at_least_once"),
+		log.NewLogger(os.Stdout, fanOutRetryLogConfig), metrics.DudType{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgChan := make(chan types.Message)
+	if err = o.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+
+	res := roundTrip(t, o, msgChan, types.Message{Parts: [][]byte{[]byte("foo")}})
+	if res.Error() != nil {
+		t.Errorf("Expected dead-lettered message to be acked as success, got: %v", res.Error())
+	}
+
+	o.CloseAsync()
+	if err = o.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFanOutRetryDoesNotAckWhenDeadLetterFails(t *testing.T) {
+	failErr := fmt.Errorf("always fails")
+	dlErr := fmt.Errorf("dead letter down")
+	outputs := []types.Consumer{
+		newMockConsumer(func(types.Message) error { return failErr }),
+	}
+	deadLetter := newMockConsumer(func(types.Message) error { return dlErr })
+
+	o, err := NewFanOutRetry(
+		outputs, deadLetter, fastRetryConfig("at_least_once"),
+		log.NewLogger(os.Stdout, fanOutRetryLogConfig), metrics.DudType{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgChan := make(chan types.Message)
+	if err = o.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+
+	res := roundTrip(t, o, msgChan, types.Message{Parts: [][]byte{[]byte("foo")}})
+	if res.Error() == nil {
+		t.Error("Expected an error when the dead-letter write itself fails, message must not be lost")
+	}
+	mapped, ok := res.(*types.MappedResponse)
+	if !ok {
+		t.Fatalf("Expected a *types.MappedResponse, got %T", res)
+	}
+	if _, exists := mapped.Errors[0]; !exists {
+		t.Error("Expected output 0's error to still be reported")
+	}
+
+	o.CloseAsync()
+	if err = o.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFanOutRetryBestEffortAcksOnPartialFailure(t *testing.T) {
+	outputs := []types.Consumer{
+		newMockConsumer(func(types.Message) error { return nil }),
+		newMockConsumer(func(types.Message) error { return fmt.Errorf("down") }),
+	}
+
+	o, err := NewFanOutRetry(
+		outputs, nil, fastRetryConfig("best_effort"),
+		log.NewLogger(os.Stdout, fanOutRetryLogConfig), metrics.DudType{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgChan := make(chan types.Message)
+	if err = o.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+
+	res := roundTrip(t, o, msgChan, types.Message{Parts: [][]byte{[]byte("foo")}})
+	if res.Error() != nil {
+		t.Errorf("best_effort should ack as long as one output succeeded, got: %v", res.Error())
+	}
+
+	o.CloseAsync()
+	if err = o.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
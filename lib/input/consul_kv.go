@@ -0,0 +1,241 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package input
+
+import (
+	"sync/atomic"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/jeffail/benthos/lib/types"
+	"github.com/jeffail/util/log"
+	"github.com/jeffail/util/metrics"
+)
+
+func init() {
+	constructors["consul_kv"] = NewConsulKV
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// ConsulKVConfig - Configuration for the ConsulKV input type.
+type ConsulKVConfig struct {
+	Address   string `json:"address" yaml:"address"`
+	Token     string `json:"token" yaml:"token"`
+	TLSCAFile string `json:"tls_ca_file" yaml:"tls_ca_file"`
+	Prefix    string `json:"prefix" yaml:"prefix"`
+	Mode      string `json:"mode" yaml:"mode"`
+}
+
+// NewConsulKVConfig - Creates a new ConsulKVConfig with default values.
+func NewConsulKVConfig() ConsulKVConfig {
+	return ConsulKVConfig{
+		Address: "localhost:8500",
+		Token:   "",
+		Prefix:  "benthos",
+		Mode:    "list-watch",
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// ConsulKV - An input type that reads a prefix of a Consul K/V store, either draining it once or
+// long-polling the blocking-query API for changes, emitting a message per change containing the
+// changed keys' values.
+type ConsulKV struct {
+	running int32
+
+	conf   ConsulKVConfig
+	logger log.Modular
+	stats  metrics.Type
+
+	client *consulapi.Client
+
+	messages  chan types.Message
+	responses <-chan types.Response
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+// NewConsulKV - Create a new ConsulKV input type.
+func NewConsulKV(conf Config, logger log.Modular, stats metrics.Type) (Type, error) {
+	cConf := consulapi.DefaultConfig()
+	cConf.Address = conf.ConsulKV.Address
+	cConf.Token = conf.ConsulKV.Token
+	if len(conf.ConsulKV.TLSCAFile) > 0 {
+		cConf.TLSConfig.CAFile = conf.ConsulKV.TLSCAFile
+	}
+
+	client, err := consulapi.NewClient(cConf)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ConsulKV{
+		running:    1,
+		conf:       conf.ConsulKV,
+		logger:     logger.NewModule(".input.consul_kv"),
+		stats:      stats,
+		client:     client,
+		messages:   make(chan types.Message),
+		closeChan:  make(chan struct{}),
+		closedChan: make(chan struct{}),
+	}
+	return c, nil
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// StartListening - Sets the channel used for reading responses and begins the read/watch loop.
+func (c *ConsulKV) StartListening(responses <-chan types.Response) error {
+	if c.responses != nil {
+		return types.ErrAlreadyStarted
+	}
+	c.responses = responses
+
+	if c.conf.Mode == "drain" {
+		go c.loopDrain()
+	} else {
+		go c.loopWatch()
+	}
+	return nil
+}
+
+// loopDrain - Performs a single one-shot read of all keys under the configured prefix.
+func (c *ConsulKV) loopDrain() {
+	defer func() {
+		close(c.messages)
+		close(c.closedChan)
+	}()
+
+	kv := c.client.KV()
+	pairs, _, err := kv.List(c.conf.Prefix, nil)
+	if err != nil {
+		c.logger.Errorf("Failed to list consul kv prefix %v: %v\n", c.conf.Prefix, err)
+		return
+	}
+
+	var msg types.Message
+	for _, pair := range pairs {
+		msg.Parts = append(msg.Parts, pair.Value)
+	}
+	if len(msg.Parts) == 0 {
+		return
+	}
+
+	select {
+	case c.messages <- msg:
+	case <-c.closeChan:
+		return
+	}
+	select {
+	case <-c.responses:
+	case <-c.closeChan:
+		return
+	}
+}
+
+// loopWatch - Long-polls the blocking query API, emitting a message each time the watched prefix
+// changes.
+func (c *ConsulKV) loopWatch() {
+	defer func() {
+		close(c.messages)
+		close(c.closedChan)
+	}()
+
+	kv := c.client.KV()
+	var lastIndex uint64
+	lastModified := map[string]uint64{}
+
+	for atomic.LoadInt32(&c.running) == 1 {
+		opts := &consulapi.QueryOptions{
+			WaitIndex: lastIndex,
+			WaitTime:  5 * time.Minute,
+		}
+		pairs, meta, err := kv.List(c.conf.Prefix, opts)
+		if err != nil {
+			c.logger.Errorf("Failed to watch consul kv prefix %v: %v\n", c.conf.Prefix, err)
+			select {
+			case <-time.After(time.Second):
+			case <-c.closeChan:
+				return
+			}
+			continue
+		}
+		if meta.LastIndex == lastIndex {
+			continue
+		}
+		lastIndex = meta.LastIndex
+
+		// Only the keys whose ModifyIndex actually moved since our last observation are reported,
+		// rather than the whole prefix, so a single key update doesn't re-emit every sibling.
+		currentModified := make(map[string]uint64, len(pairs))
+		var msg types.Message
+		for _, pair := range pairs {
+			currentModified[pair.Key] = pair.ModifyIndex
+			if prevIndex, ok := lastModified[pair.Key]; !ok || prevIndex != pair.ModifyIndex {
+				msg.Parts = append(msg.Parts, pair.Value)
+			}
+		}
+		lastModified = currentModified
+		if len(msg.Parts) == 0 {
+			continue
+		}
+
+		select {
+		case c.messages <- msg:
+		case <-c.closeChan:
+			return
+		}
+		select {
+		case <-c.responses:
+		case <-c.closeChan:
+			return
+		}
+	}
+}
+
+// MessageChan - Returns the channel used for reading messages from this input.
+func (c *ConsulKV) MessageChan() <-chan types.Message {
+	return c.messages
+}
+
+// CloseAsync - Shuts down the ConsulKV input and stops processing.
+func (c *ConsulKV) CloseAsync() {
+	if atomic.CompareAndSwapInt32(&c.running, 1, 0) {
+		close(c.closeChan)
+	}
+}
+
+// WaitForClose - Blocks until the ConsulKV input has closed down.
+func (c *ConsulKV) WaitForClose(timeout time.Duration) error {
+	select {
+	case <-c.closedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+//--------------------------------------------------------------------------------------------------
@@ -0,0 +1,336 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package input
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/jeffail/benthos/lib/types"
+	"github.com/jeffail/util/log"
+	"github.com/jeffail/util/metrics"
+)
+
+func init() {
+	constructors["http_server"] = NewHTTPServer
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// HTTPServerConfig - Configuration for the HTTPServer input type.
+type HTTPServerConfig struct {
+	Address string         `json:"address" yaml:"address"`
+	Path    string         `json:"path" yaml:"path"`
+	TLS     VaultTLSConfig `json:"tls" yaml:"tls"`
+
+	// ResponseMode - "sync" replies 200 without waiting for the message to be acked, matching the
+	// original fire-and-forget behaviour. "stream" instead holds the connection open and
+	// streams back a newline-delimited JSON progress feed for the message's lifecycle.
+	ResponseMode string `json:"response_mode" yaml:"response_mode"`
+}
+
+// NewHTTPServerConfig - Creates a new HTTPServerConfig with default values.
+func NewHTTPServerConfig() HTTPServerConfig {
+	return HTTPServerConfig{
+		Address:      "localhost:8080",
+		Path:         "/post",
+		TLS:          NewVaultTLSConfig(),
+		ResponseMode: "sync",
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// progressFrame - A single newline-delimited JSON frame of the "stream" response mode, reporting
+// a message's lifecycle from being queued through to its final ack/nack.
+type progressFrame struct {
+	Status   string          `json:"status"`
+	ID       string          `json:"id"`
+	Progress string          `json:"progress,omitempty"`
+	Aux      json.RawMessage `json:"aux,omitempty"`
+}
+
+// auxFromResponse - Renders a types.Response as an aux payload, unpacking a types.MappedResponse
+// into per-output results when one is returned. With the at_least_once guarantee,
+// broker.FanOutRetry returns a MappedResponse whenever an output still failed after its retries
+// and the message was not dead-lettered, including partial failures, so aux reflects those
+// per-output errors; broker.FanOut, and broker.FanOutRetry with best_effort, only return a
+// MappedResponse once every output has failed, so aux behind them is only ever populated on total
+// failure.
+func auxFromResponse(res types.Response) json.RawMessage {
+	mapped, ok := res.(*types.MappedResponse)
+	if !ok {
+		return nil
+	}
+	results := map[string]string{}
+	for i, err := range mapped.Errors {
+		results[fmt.Sprintf("output.%v", i)] = err.Error()
+	}
+	raw, err := json.Marshal(results)
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// HTTPServer - An input type that serves HTTPServerConfig.Path as an endpoint and pushes any
+// posted payloads (single or multipart) onto its messages channel.
+type HTTPServer struct {
+	running int32
+
+	conf   HTTPServerConfig
+	logger log.Modular
+	stats  metrics.Type
+
+	reqCount int64
+
+	messages  chan types.Message
+	responses <-chan types.Response
+
+	server     *http.Server
+	certLoader *vaultCertLoader
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+// NewHTTPServer - Create a new HTTPServer input type.
+func NewHTTPServer(conf Config, logger log.Modular, stats metrics.Type) (Type, error) {
+	h := &HTTPServer{
+		running:    1,
+		conf:       conf.HTTPServer,
+		logger:     logger.NewModule(".input.http_server"),
+		stats:      stats,
+		messages:   make(chan types.Message),
+		closeChan:  make(chan struct{}),
+		closedChan: make(chan struct{}),
+	}
+
+	switch h.conf.ResponseMode {
+	case "sync", "stream":
+	default:
+		return nil, fmt.Errorf("response_mode must be 'sync' or 'stream', got '%v'", h.conf.ResponseMode)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(h.conf.Path, h.handler)
+	h.server = &http.Server{Addr: h.conf.Address, Handler: mux}
+
+	if h.conf.TLS.Enabled {
+		loader, err := newVaultCertLoader(h.conf.TLS, h.logger)
+		if err != nil {
+			return nil, err
+		}
+		h.certLoader = loader
+		h.server.TLSConfig = loader.tlsConfig()
+	}
+
+	return h, nil
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// readMessage - Parses a posted payload (single or multipart) into a types.Message.
+func (h *HTTPServer) readMessage(r *http.Request) (types.Message, error) {
+	var msg types.Message
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && len(mediaType) > 9 && mediaType[:9] == "multipart" {
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			p, perr := mr.NextPart()
+			if perr != nil {
+				break
+			}
+			partBytes, rerr := ioutil.ReadAll(p)
+			if rerr != nil {
+				return msg, rerr
+			}
+			msg.Parts = append(msg.Parts, partBytes)
+		}
+		return msg, nil
+	}
+
+	bodyBytes, rerr := ioutil.ReadAll(r.Body)
+	if rerr != nil {
+		return msg, rerr
+	}
+	msg.Parts = [][]byte{bodyBytes}
+	return msg, nil
+}
+
+// handler - The HTTP handler for receiving posted messages, dispatching to the sync or stream
+// response mode as configured.
+func (h *HTTPServer) handler(w http.ResponseWriter, r *http.Request) {
+	msg, err := h.readMessage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if h.conf.ResponseMode == "stream" {
+		h.handleStream(w, msg)
+		return
+	}
+	h.handleSync(w, msg)
+}
+
+// handleSync - Replies 200 as soon as the payload is parsed, then enqueues the message and waits
+// for its response, as covered by TestHTTPBasic. The response is not reported to the client; an
+// error in it is only logged.
+func (h *HTTPServer) handleSync(w http.ResponseWriter, msg types.Message) {
+	w.WriteHeader(http.StatusOK)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	select {
+	case h.messages <- msg:
+	case <-h.closeChan:
+		return
+	}
+
+	select {
+	case res, open := <-h.responses:
+		if open && res.Error() != nil {
+			h.logger.Errorf("Failed to dispatch message: %v\n", res.Error())
+		}
+	case <-h.closeChan:
+	}
+}
+
+// handleStream - Holds the connection open and streams newline-delimited JSON frames reporting
+// the message's lifecycle: "queued" once handed to MessageChan, then "ack"/"nack" once the
+// corresponding response arrives, carrying any broker aux results.
+func (h *HTTPServer) handleStream(w http.ResponseWriter, msg types.Message) {
+	id := fmt.Sprintf("%v", atomic.AddInt64(&h.reqCount, 1))
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	writeFrame := func(f progressFrame) {
+		if err := enc.Encode(f); err != nil {
+			h.logger.Errorf("Failed to write progress frame to streaming client: %v\n", err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	select {
+	case h.messages <- msg:
+	case <-h.closeChan:
+		http.Error(w, "Server closing", http.StatusServiceUnavailable)
+		return
+	}
+	writeFrame(progressFrame{
+		Status:   "queued",
+		ID:       id,
+		Progress: fmt.Sprintf("0/%v", len(msg.Parts)),
+	})
+
+	select {
+	case res, open := <-h.responses:
+		if !open {
+			writeFrame(progressFrame{Status: "nack", ID: id})
+			return
+		}
+		if res.Error() != nil {
+			writeFrame(progressFrame{
+				Status:   "nack",
+				ID:       id,
+				Progress: fmt.Sprintf("0/%v", len(msg.Parts)),
+				Aux:      auxFromResponse(res),
+			})
+			return
+		}
+		writeFrame(progressFrame{
+			Status:   "ack",
+			ID:       id,
+			Progress: fmt.Sprintf("%v/%v", len(msg.Parts), len(msg.Parts)),
+			Aux:      auxFromResponse(res),
+		})
+	case <-h.closeChan:
+		writeFrame(progressFrame{Status: "nack", ID: id})
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// StartListening - Sets the channel used for reading responses and begins serving HTTP requests.
+func (h *HTTPServer) StartListening(responses <-chan types.Response) error {
+	if h.responses != nil {
+		return types.ErrAlreadyStarted
+	}
+	h.responses = responses
+
+	go func() {
+		if h.certLoader != nil {
+			// Certificate and key are sourced dynamically via h.server.TLSConfig.GetCertificate,
+			// so no file paths are passed here.
+			h.server.ListenAndServeTLS("", "")
+		} else {
+			h.server.ListenAndServe()
+		}
+	}()
+
+	return nil
+}
+
+// MessageChan - Returns the channel used for reading messages from this input.
+func (h *HTTPServer) MessageChan() <-chan types.Message {
+	return h.messages
+}
+
+// CloseAsync - Shuts down the HTTPServer input and stops processing requests.
+func (h *HTTPServer) CloseAsync() {
+	if atomic.CompareAndSwapInt32(&h.running, 1, 0) {
+		close(h.closeChan)
+		h.server.Close()
+		if h.certLoader != nil {
+			h.certLoader.close()
+		}
+		close(h.closedChan)
+	}
+}
+
+// WaitForClose - Blocks until the HTTPServer input has closed down.
+func (h *HTTPServer) WaitForClose(timeout time.Duration) error {
+	select {
+	case <-h.closedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+//--------------------------------------------------------------------------------------------------
@@ -0,0 +1,254 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package input
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/jeffail/util/log"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+// VaultTLSConfig - Configuration for sourcing the HTTPServer's TLS certificate and key from
+// HashiCorp Vault instead of local files.
+type VaultTLSConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+
+	Address string `json:"address" yaml:"address"`
+	CAFile  string `json:"ca_file" yaml:"ca_file"`
+
+	// AuthMethod - Either "token" or "approle".
+	AuthMethod string `json:"auth_method" yaml:"auth_method"`
+	Token      string `json:"token" yaml:"token"`
+	RoleID     string `json:"role_id" yaml:"role_id"`
+	SecretID   string `json:"secret_id" yaml:"secret_id"`
+
+	// MountPath - The KV mount holding the certificate, e.g. "secret".
+	MountPath string `json:"mount_path" yaml:"mount_path"`
+	// Path - The path under MountPath holding the cert/key fields.
+	Path string `json:"path" yaml:"path"`
+	// CertField/KeyField - The field names within the secret holding the PEM cert and key.
+	CertField string `json:"cert_field" yaml:"cert_field"`
+	KeyField  string `json:"key_field" yaml:"key_field"`
+
+	RereadInterval time.Duration `json:"reread_interval" yaml:"reread_interval"`
+}
+
+// NewVaultTLSConfig - Creates a new VaultTLSConfig with default values.
+func NewVaultTLSConfig() VaultTLSConfig {
+	return VaultTLSConfig{
+		Enabled:        false,
+		Address:        "https://localhost:8200",
+		AuthMethod:     "token",
+		MountPath:      "secret",
+		Path:           "benthos/tls",
+		CertField:      "certificate",
+		KeyField:       "private_key",
+		RereadInterval: time.Minute * 5,
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// vaultCertLoader - Periodically reads a certificate/key pair from Vault and exposes it via
+// GetCertificate so that *tls.Config can hot-reload rotated certs without dropping connections.
+type vaultCertLoader struct {
+	conf   VaultTLSConfig
+	logger log.Modular
+
+	client *vaultapi.Client
+	kvIsV2 bool
+
+	mut  sync.RWMutex
+	cert *tls.Certificate
+
+	closeChan chan struct{}
+}
+
+// newVaultCertLoader - Creates a loader, authenticates with Vault, detects the KV mount version,
+// and performs an initial synchronous read so the first TLS handshake has a certificate ready.
+func newVaultCertLoader(conf VaultTLSConfig, logger log.Modular) (*vaultCertLoader, error) {
+	vConf := vaultapi.DefaultConfig()
+	vConf.Address = conf.Address
+	if len(conf.CAFile) > 0 {
+		if err := vConf.ConfigureTLS(&vaultapi.TLSConfig{CACert: conf.CAFile}); err != nil {
+			return nil, err
+		}
+	}
+
+	client, err := vaultapi.NewClient(vConf)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &vaultCertLoader{
+		conf:      conf,
+		logger:    logger,
+		client:    client,
+		closeChan: make(chan struct{}),
+	}
+
+	if err = v.authenticate(); err != nil {
+		return nil, err
+	}
+	if err = v.detectKVVersion(); err != nil {
+		return nil, err
+	}
+	if err = v.reload(); err != nil {
+		return nil, err
+	}
+
+	go v.loop()
+	return v, nil
+}
+
+// authenticate - Logs into Vault using either a static token or the AppRole auth method.
+func (v *vaultCertLoader) authenticate() error {
+	switch v.conf.AuthMethod {
+	case "approle":
+		secret, err := v.client.Logical().Write("auth/approle/login", map[string]interface{}{
+			"role_id":   v.conf.RoleID,
+			"secret_id": v.conf.SecretID,
+		})
+		if err != nil {
+			return err
+		}
+		if secret == nil || secret.Auth == nil {
+			return fmt.Errorf("approle login returned no auth info")
+		}
+		v.client.SetToken(secret.Auth.ClientToken)
+	default:
+		v.client.SetToken(v.conf.Token)
+	}
+	return nil
+}
+
+// detectKVVersion - Probes the mount's tuning info to determine whether it's a KV v1 or v2
+// secrets engine, since v2 nests the payload under /data/ and /metadata/.
+func (v *vaultCertLoader) detectKVVersion() error {
+	mount := strings.Trim(v.conf.MountPath, "/")
+	secret, err := v.client.Logical().Read(fmt.Sprintf("sys/internal/ui/mounts/%v", mount))
+	if err != nil || secret == nil {
+		// Fall back to v1 semantics if the introspection endpoint isn't available.
+		v.kvIsV2 = false
+		return nil
+	}
+	if options, ok := secret.Data["options"].(map[string]interface{}); ok {
+		if version, ok := options["version"].(string); ok && version == "2" {
+			v.kvIsV2 = true
+		}
+	}
+	return nil
+}
+
+// readPath - Returns the logical read path for the configured secret, rewriting it for the v2
+// "/data/" envelope when required.
+func (v *vaultCertLoader) readPath() string {
+	mount := strings.Trim(v.conf.MountPath, "/")
+	path := strings.Trim(v.conf.Path, "/")
+	if v.kvIsV2 {
+		return fmt.Sprintf("%v/data/%v", mount, path)
+	}
+	return fmt.Sprintf("%v/%v", mount, path)
+}
+
+// reload - Reads the secret from Vault and swaps in a freshly parsed certificate.
+func (v *vaultCertLoader) reload() error {
+	secret, err := v.client.Logical().Read(v.readPath())
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		return fmt.Errorf("no secret found at %v", v.readPath())
+	}
+
+	data := secret.Data
+	if v.kvIsV2 {
+		nested, ok := secret.Data["data"].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("malformed kv2 secret at %v", v.readPath())
+		}
+		data = nested
+	}
+
+	certPEM, _ := data[v.conf.CertField].(string)
+	keyPEM, _ := data[v.conf.KeyField].(string)
+	if len(certPEM) == 0 || len(keyPEM) == 0 {
+		return fmt.Errorf("secret at %v missing cert/key fields", v.readPath())
+	}
+
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return err
+	}
+
+	v.mut.Lock()
+	v.cert = &cert
+	v.mut.Unlock()
+	return nil
+}
+
+// loop - Periodically re-reads the certificate so rotated certs are picked up without a restart.
+func (v *vaultCertLoader) loop() {
+	ticker := time.NewTicker(v.conf.RereadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := v.reload(); err != nil {
+				v.logger.Errorf("Failed to reload TLS certificate from vault: %v\n", err)
+			}
+		case <-v.closeChan:
+			return
+		}
+	}
+}
+
+// getCertificate - Implements the signature required by tls.Config.GetCertificate, handing live
+// connections the most recently loaded certificate.
+func (v *vaultCertLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+	if v.cert == nil {
+		return nil, fmt.Errorf("no certificate loaded from vault yet")
+	}
+	return v.cert, nil
+}
+
+// tlsConfig - Builds a *tls.Config that sources its certificate from this loader.
+func (v *vaultCertLoader) tlsConfig() *tls.Config {
+	return &tls.Config{GetCertificate: v.getCertificate}
+}
+
+// close - Stops the reload loop.
+func (v *vaultCertLoader) close() {
+	close(v.closeChan)
+}
+
+//--------------------------------------------------------------------------------------------------
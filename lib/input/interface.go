@@ -0,0 +1,48 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package input
+
+import (
+	"time"
+
+	"github.com/jeffail/benthos/lib/types"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+// Type - The standard interface of an input type.
+type Type interface {
+	// StartListening - Sets the channel used for reading responses for each sent message.
+	StartListening(<-chan types.Response) error
+
+	// MessageChan - Returns the channel used for reading messages from this input.
+	MessageChan() <-chan types.Message
+
+	// CloseAsync - Triggers the shut down of this input and returns immediately.
+	CloseAsync()
+
+	// WaitForClose - Blocks until the input has closed down.
+	WaitForClose(timeout time.Duration) error
+}
+
+//--------------------------------------------------------------------------------------------------
@@ -0,0 +1,180 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package input
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jeffail/benthos/lib/types"
+	"github.com/jeffail/util/log"
+	"github.com/jeffail/util/metrics"
+)
+
+func init() {
+	constructors["websocket"] = NewWebSocket
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// WebSocketConfig - Configuration for the WebSocket input type. It reuses the HTTPServer config
+// surface since both types listen on an address/path pair.
+type WebSocketConfig HTTPServerConfig
+
+// NewWebSocketConfig - Creates a new WebSocketConfig with default values.
+func NewWebSocketConfig() WebSocketConfig {
+	return WebSocketConfig(NewHTTPServerConfig())
+}
+
+//--------------------------------------------------------------------------------------------------
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// WebSocket - An input type that serves WebSocketConfig.Path as a websocket endpoint and streams
+// each received frame onto its messages channel as a single-part message.
+type WebSocket struct {
+	running int32
+
+	conf   WebSocketConfig
+	logger log.Modular
+	stats  metrics.Type
+
+	messages  chan types.Message
+	responses <-chan types.Response
+
+	server *http.Server
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+// NewWebSocket - Create a new WebSocket input type.
+func NewWebSocket(conf Config, logger log.Modular, stats metrics.Type) (Type, error) {
+	w := &WebSocket{
+		running:    1,
+		conf:       conf.WebSocket,
+		logger:     logger.NewModule(".input.websocket"),
+		stats:      stats,
+		messages:   make(chan types.Message),
+		closeChan:  make(chan struct{}),
+		closedChan: make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(w.conf.Path, w.handler)
+	w.server = &http.Server{Addr: w.conf.Address, Handler: mux}
+
+	return w, nil
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// handler - Upgrades incoming connections and streams each frame onto the messages channel.
+func (w *WebSocket) handler(rw http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		w.logger.Errorf("Failed to upgrade websocket connection: %v\n", err)
+		return
+	}
+	defer conn.Close()
+
+	for {
+		mType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg types.Message
+		if mType == websocket.TextMessage {
+			msg.Parts = [][]byte{[]byte(string(data))}
+		} else {
+			msg.Parts = [][]byte{data}
+		}
+
+		select {
+		case w.messages <- msg:
+		case <-w.closeChan:
+			return
+		}
+
+		select {
+		case res, open := <-w.responses:
+			if !open {
+				return
+			}
+			if res.Error() != nil {
+				w.logger.Errorf("Failed to dispatch websocket message: %v\n", res.Error())
+			}
+		case <-w.closeChan:
+			return
+		}
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// StartListening - Sets the channel used for reading responses and begins serving connections.
+func (w *WebSocket) StartListening(responses <-chan types.Response) error {
+	if w.responses != nil {
+		return types.ErrAlreadyStarted
+	}
+	w.responses = responses
+
+	go func() {
+		w.server.ListenAndServe()
+	}()
+
+	return nil
+}
+
+// MessageChan - Returns the channel used for reading messages from this input.
+func (w *WebSocket) MessageChan() <-chan types.Message {
+	return w.messages
+}
+
+// CloseAsync - Shuts down the WebSocket input and stops processing requests.
+func (w *WebSocket) CloseAsync() {
+	if atomic.CompareAndSwapInt32(&w.running, 1, 0) {
+		close(w.closeChan)
+		w.server.Close()
+		close(w.closedChan)
+	}
+}
+
+// WaitForClose - Blocks until the WebSocket input has closed down.
+func (w *WebSocket) WaitForClose(timeout time.Duration) error {
+	select {
+	case <-w.closedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+//--------------------------------------------------------------------------------------------------
@@ -0,0 +1,74 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package output
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestConsulKVValidateKeyTemplate(t *testing.T) {
+	valid := []string{
+		"benthos",
+		"benthos/{{uuid}}",
+		"benthos/{{ uuid }}",
+		"benthos/{{uuid}}/suffix",
+	}
+	for _, tmpl := range valid {
+		if err := validateKeyTemplate(tmpl); err != nil {
+			t.Errorf("Unexpected error for %q: %v", tmpl, err)
+		}
+	}
+
+	invalid := []string{
+		"benthos/{{metadata.key}}",
+		"benthos/{{foo}}",
+	}
+	for _, tmpl := range invalid {
+		if err := validateKeyTemplate(tmpl); err == nil {
+			t.Errorf("Expected an error for unsupported placeholder in %q", tmpl)
+		}
+	}
+}
+
+func TestConsulKVGenKeySubstitutesUUID(t *testing.T) {
+	uuidRegexp := regexp.MustCompile(
+		`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`,
+	)
+
+	for _, prefix := range []string{"benthos/{{uuid}}", "benthos/{{ uuid }}"} {
+		c := &ConsulKV{conf: ConsulKVConfig{KeyPrefix: prefix}}
+
+		keyOne := c.genKey()
+		keyTwo := c.genKey()
+
+		if keyOne == keyTwo {
+			t.Errorf("Expected distinct keys per call for %q, got %v twice", prefix, keyOne)
+		}
+		for _, key := range []string{keyOne, keyTwo} {
+			if len(key) <= len("benthos/") || !uuidRegexp.MatchString(key[len("benthos/"):]) {
+				t.Errorf("Expected key %q to be benthos/<uuid>", key)
+			}
+		}
+	}
+}
@@ -0,0 +1,248 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package output
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/jeffail/benthos/lib/types"
+	"github.com/jeffail/util/log"
+	"github.com/jeffail/util/metrics"
+)
+
+func init() {
+	constructors["syslog"] = NewSyslog
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// SyslogConfig - Configuration for the Syslog output type.
+type SyslogConfig struct {
+	// Network - "udp", "tcp", "tcp+tls", or "" for the local /dev/log socket.
+	Network string `json:"network" yaml:"network"`
+	Address string `json:"address" yaml:"address"`
+
+	// Framing - "rfc3164" or "rfc5424".
+	Framing string `json:"framing" yaml:"framing"`
+
+	Facility int    `json:"facility" yaml:"facility"`
+	Severity int    `json:"severity" yaml:"severity"`
+	Hostname string `json:"hostname" yaml:"hostname"`
+	Tag      string `json:"tag" yaml:"tag"`
+}
+
+// NewSyslogConfig - Creates a new SyslogConfig with default values.
+func NewSyslogConfig() SyslogConfig {
+	hostname, _ := os.Hostname()
+	return SyslogConfig{
+		Network:  "",
+		Address:  "",
+		Framing:  "rfc3164",
+		Facility: 1, // user-level messages
+		Severity: 6, // informational
+		Hostname: hostname,
+		Tag:      "benthos",
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// Syslog - An output type that ships each message part as a syslog record over UDP, TCP, TLS, or
+// the local /dev/log socket.
+type Syslog struct {
+	running int32
+
+	conf   SyslogConfig
+	logger log.Modular
+	stats  metrics.Type
+
+	pid int
+
+	conn net.Conn
+
+	messages     <-chan types.Message
+	responseChan chan types.Response
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+// NewSyslog - Create a new Syslog output type.
+func NewSyslog(conf Config, logger log.Modular, stats metrics.Type) (Type, error) {
+	s := &Syslog{
+		running:      1,
+		conf:         conf.Syslog,
+		logger:       logger.NewModule(".output.syslog"),
+		stats:        stats,
+		pid:          os.Getpid(),
+		responseChan: make(chan types.Response),
+		closeChan:    make(chan struct{}),
+		closedChan:   make(chan struct{}),
+	}
+
+	conn, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+
+	return s, nil
+}
+
+// dial - Opens the configured transport: a local /dev/log socket when Network is empty, otherwise
+// a UDP, TCP, or TLS-wrapped TCP connection to Address.
+func (s *Syslog) dial() (net.Conn, error) {
+	if s.conf.Network == "" {
+		return net.Dial("unixgram", "/dev/log")
+	}
+	if s.conf.Network == "tcp+tls" {
+		return tls.Dial("tcp", s.conf.Address, nil)
+	}
+	return net.Dial(s.conf.Network, s.conf.Address)
+}
+
+// reconnect - Re-dials the transport after a write failure, so a transient outage (dropped TCP/TLS
+// peer) doesn't permanently fail every subsequent part. The old connection is closed first; the
+// failure to redial is logged but otherwise swallowed, since the next write attempt will surface it
+// again via its own error.
+func (s *Syslog) reconnect() {
+	s.conn.Close()
+	conn, err := s.dial()
+	if err != nil {
+		s.logger.Errorf("Failed to reconnect syslog transport: %v\n", err)
+		return
+	}
+	s.conn = conn
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// severity - Resolves the severity to frame each record with. types.Message carries no per-part
+// metadata today, so this is always the configured default; a per-message override will need a
+// SeverityMetadataKey config field once that's available, matching the "{{uuid}}"-only limitation
+// on the Consul K/V output's key_prefix.
+func (s *Syslog) severity() int {
+	return s.conf.Severity
+}
+
+// frame - Renders a single message part as a syslog record using the configured framing.
+func (s *Syslog) frame(part []byte) []byte {
+	priority := s.conf.Facility*8 + s.severity()
+
+	if s.conf.Framing == "rfc5424" {
+		return []byte(fmt.Sprintf(
+			"<%d>1 %s %s %s %d - - %s",
+			priority,
+			time.Now().Format(time.RFC3339),
+			s.conf.Hostname,
+			s.conf.Tag,
+			s.pid,
+			part,
+		))
+	}
+
+	// rfc3164
+	return []byte(fmt.Sprintf(
+		"<%d>%s %s %s[%d]: %s",
+		priority,
+		time.Now().Format(time.Stamp),
+		s.conf.Hostname,
+		s.conf.Tag,
+		s.pid,
+		part,
+	))
+}
+
+// loop - Internal loop ships each incoming message's parts as syslog records.
+func (s *Syslog) loop() {
+	defer func() {
+		s.conn.Close()
+		close(s.responseChan)
+		close(s.closedChan)
+	}()
+
+	var open bool
+	for atomic.LoadInt32(&s.running) == 1 {
+		var msg types.Message
+		if msg, open = <-s.messages; !open {
+			return
+		}
+
+		var err error
+		for _, part := range msg.Parts {
+			if _, err = s.conn.Write(s.frame(part)); err != nil {
+				s.logger.Errorf("Failed to write syslog record: %v\n", err)
+				s.stats.Incr("output.syslog.error", 1)
+				s.reconnect()
+				break
+			}
+			s.stats.Incr("output.syslog.messages.sent", 1)
+		}
+
+		select {
+		case s.responseChan <- types.NewSimpleResponse(err):
+		case <-s.closeChan:
+			return
+		}
+	}
+}
+
+// StartReceiving - Assigns a new messages channel for the output to read.
+func (s *Syslog) StartReceiving(msgs <-chan types.Message) error {
+	if s.messages != nil {
+		return types.ErrAlreadyStarted
+	}
+	s.messages = msgs
+
+	go s.loop()
+	return nil
+}
+
+// ResponseChan - Returns the response channel.
+func (s *Syslog) ResponseChan() <-chan types.Response {
+	return s.responseChan
+}
+
+// CloseAsync - Shuts down the Syslog output and stops processing messages.
+func (s *Syslog) CloseAsync() {
+	if atomic.CompareAndSwapInt32(&s.running, 1, 0) {
+		close(s.closeChan)
+	}
+}
+
+// WaitForClose - Blocks until the Syslog output has closed down.
+func (s *Syslog) WaitForClose(timeout time.Duration) error {
+	select {
+	case <-s.closedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+//--------------------------------------------------------------------------------------------------
@@ -0,0 +1,268 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package output
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jeffail/benthos/lib/types"
+	"github.com/jeffail/util/log"
+	"github.com/jeffail/util/metrics"
+)
+
+func init() {
+	constructors["websocket"] = NewWebSocket
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// WebSocketConfig - Configuration for the WebSocket output type.
+type WebSocketConfig struct {
+	Address string `json:"address" yaml:"address"`
+	Path    string `json:"path" yaml:"path"`
+}
+
+// NewWebSocketConfig - Creates a new WebSocketConfig with default values.
+func NewWebSocketConfig() WebSocketConfig {
+	return WebSocketConfig{
+		Address: "localhost:8081",
+		Path:    "/ws",
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// outboundBufferSize - The number of pending frames buffered per client before we start dropping
+// frames for that client rather than block the broker on a slow reader.
+const outboundBufferSize = 100
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// WebSocket - An output type that serves WebSocketConfig.Path as a websocket endpoint and
+// broadcasts each message part it receives to every connected client.
+type WebSocket struct {
+	running int32
+
+	conf   WebSocketConfig
+	logger log.Modular
+	stats  metrics.Type
+
+	messages     <-chan types.Message
+	responseChan chan types.Response
+
+	server *http.Server
+
+	clientsMux sync.Mutex
+	clients    map[*wsClient]struct{}
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+// wsClient - A single connected websocket client with its own bounded outbound queue so a slow
+// reader can't back-pressure the broker.
+type wsClient struct {
+	conn   *websocket.Conn
+	outbox chan []byte
+	logger log.Modular
+}
+
+// NewWebSocket - Create a new WebSocket output type.
+func NewWebSocket(conf Config, logger log.Modular, stats metrics.Type) (Type, error) {
+	w := &WebSocket{
+		running:      1,
+		conf:         conf.WebSocket,
+		logger:       logger.NewModule(".output.websocket"),
+		stats:        stats,
+		responseChan: make(chan types.Response),
+		clients:      map[*wsClient]struct{}{},
+		closeChan:    make(chan struct{}),
+		closedChan:   make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(w.conf.Path, w.handler)
+	w.server = &http.Server{Addr: w.conf.Address, Handler: mux}
+
+	return w, nil
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// handler - Upgrades incoming connections and registers them to receive broadcasts.
+func (w *WebSocket) handler(rw http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		w.logger.Errorf("Failed to upgrade websocket connection: %v\n", err)
+		return
+	}
+
+	client := &wsClient{
+		conn:   conn,
+		outbox: make(chan []byte, outboundBufferSize),
+		logger: w.logger,
+	}
+
+	w.clientsMux.Lock()
+	w.clients[client] = struct{}{}
+	w.clientsMux.Unlock()
+
+	defer func() {
+		w.clientsMux.Lock()
+		delete(w.clients, client)
+		w.clientsMux.Unlock()
+		conn.Close()
+	}()
+
+	for data := range client.outbox {
+		if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			return
+		}
+	}
+}
+
+// broadcast - Sends a frame to every connected client, dropping it for any client whose outbox is
+// full rather than blocking the main loop. Returns the number of clients the frame was dropped
+// for, and the total number of connected clients, so the caller can honour backpressure by
+// reporting an error upstream rather than silently acking a partially-delivered frame.
+func (w *WebSocket) broadcast(data []byte) (dropped, total int) {
+	w.clientsMux.Lock()
+	defer w.clientsMux.Unlock()
+
+	// CloseAsync closes every client outbox under this same lock, so once running has flipped to
+	// 0 we must not send on any of them even if our view of w.clients predates the close.
+	if atomic.LoadInt32(&w.running) == 0 {
+		return len(w.clients), len(w.clients)
+	}
+
+	total = len(w.clients)
+	for client := range w.clients {
+		select {
+		case client.outbox <- data:
+		default:
+			w.logger.Warnln("Dropping websocket frame for slow client")
+			w.stats.Incr("output.websocket.dropped", 1)
+			dropped++
+		}
+	}
+	return dropped, total
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// loop - Internal loop broadcasts each incoming message to all connected clients.
+func (w *WebSocket) loop() {
+	defer func() {
+		close(w.responseChan)
+		close(w.closedChan)
+	}()
+
+	var open bool
+	for atomic.LoadInt32(&w.running) == 1 {
+		var msg types.Message
+		if msg, open = <-w.messages; !open {
+			return
+		}
+
+		var dropped, total int
+		for _, part := range msg.Parts {
+			partDropped, partTotal := w.broadcast(part)
+			dropped += partDropped
+			total += partTotal
+		}
+		w.stats.Incr("output.websocket.messages.sent", 1)
+
+		// Honour backpressure: if any client couldn't keep up, report it upstream rather than
+		// silently acking a frame we know wasn't fully delivered.
+		var res types.Response
+		if dropped > 0 {
+			res = types.NewSimpleResponse(fmt.Errorf(
+				"dropped %v of %v frame deliveries to connected websocket clients", dropped, total,
+			))
+		} else {
+			res = types.NewSimpleResponse(nil)
+		}
+
+		select {
+		case w.responseChan <- res:
+		case <-w.closeChan:
+			return
+		}
+	}
+}
+
+// StartReceiving - Assigns a new messages channel for the output to read and begins serving
+// websocket connections, matching every other output in this package.
+func (w *WebSocket) StartReceiving(msgs <-chan types.Message) error {
+	if w.messages != nil {
+		return types.ErrAlreadyStarted
+	}
+	w.messages = msgs
+
+	go func() {
+		w.server.ListenAndServe()
+	}()
+	go w.loop()
+	return nil
+}
+
+// ResponseChan - Returns the response channel.
+func (w *WebSocket) ResponseChan() <-chan types.Response {
+	return w.responseChan
+}
+
+// CloseAsync - Shuts down the WebSocket output and stops processing messages.
+func (w *WebSocket) CloseAsync() {
+	if atomic.CompareAndSwapInt32(&w.running, 1, 0) {
+		close(w.closeChan)
+		w.server.Close()
+
+		// Unblock every handler goroutine parked on `for data := range client.outbox`.
+		w.clientsMux.Lock()
+		for client := range w.clients {
+			close(client.outbox)
+		}
+		w.clientsMux.Unlock()
+	}
+}
+
+// WaitForClose - Blocks until the WebSocket output has closed down.
+func (w *WebSocket) WaitForClose(timeout time.Duration) error {
+	select {
+	case <-w.closedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+//--------------------------------------------------------------------------------------------------